@@ -0,0 +1,229 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+)
+
+// AuthStatus represents the outcome of one step of a SASL authentication
+// exchange.
+type AuthStatus int
+
+const (
+	// AuthOk signals that the mechanism's exchange is complete and the
+	// accompanying data, if any, is ready to send to the server.
+	AuthOk AuthStatus = iota
+	// AuthContinue signals that the exchange must continue with another
+	// round of HandleData.
+	AuthContinue
+	// AuthError signals that the exchange has irrecoverably failed.
+	AuthError
+)
+
+// Auth defines a single SASL authentication mechanism, tried by the
+// handshake run from NewConnection. See WithAuthMethods.
+type Auth interface {
+	// FirstData returns the mechanism's name and the initial response to
+	// send with the AUTH command (resp may be nil), along with the
+	// resulting status.
+	FirstData() (name, resp []byte, status AuthStatus)
+
+	// HandleData processes a DATA challenge received from the server and
+	// returns the response to send back, along with the resulting status.
+	HandleData(data []byte) (resp []byte, status AuthStatus)
+}
+
+// WithAuthMethods installs methods as the list of SASL mechanisms tried, in
+// order, during the authentication handshake, replacing the default of
+// just AuthExternal(). Whenever the server rejects the mechanism currently
+// being tried, the handshake advances to the next one in the list.
+func WithAuthMethods(methods ...Auth) ConnOption {
+	return func(conn *Connection) {
+		conn.authMethods = methods
+	}
+}
+
+// authExternal implements the EXTERNAL SASL mechanism, which authenticates
+// as the connecting process' effective user id.
+type authExternal struct{}
+
+// AuthExternal returns an Auth implementing the EXTERNAL mechanism. It is
+// the default, and the only mechanism most local (unix socket) transports
+// accept.
+func AuthExternal() Auth {
+	return authExternal{}
+}
+
+func (authExternal) FirstData() (name, resp []byte, status AuthStatus) {
+	uid := strconv.Itoa(os.Geteuid())
+	return []byte("EXTERNAL"), []byte(hex.EncodeToString([]byte(uid))), AuthOk
+}
+
+func (authExternal) HandleData(data []byte) (resp []byte, status AuthStatus) {
+	// EXTERNAL never expects a DATA challenge from the server.
+	return nil, AuthError
+}
+
+// authAnonymous implements the ANONYMOUS SASL mechanism.
+type authAnonymous struct {
+	trace []byte
+}
+
+// AuthAnonymous returns an Auth implementing the ANONYMOUS mechanism,
+// needed for connecting to buses that disallow EXTERNAL (e.g. over tcp).
+// trace is an optional human-readable string, such as an application name
+// and version, sent to the server for logging purposes; pass "" to omit it.
+func AuthAnonymous(trace string) Auth {
+	return authAnonymous{[]byte(trace)}
+}
+
+func (a authAnonymous) FirstData() (name, resp []byte, status AuthStatus) {
+	return []byte("ANONYMOUS"), []byte(hex.EncodeToString(a.trace)), AuthOk
+}
+
+func (authAnonymous) HandleData(data []byte) (resp []byte, status AuthStatus) {
+	// ANONYMOUS never expects a DATA challenge from the server.
+	return nil, AuthError
+}
+
+// auth runs the SASL authentication handshake over conn.transport, trying
+// conn.authMethods in order until one is accepted or all are rejected.
+func (conn *Connection) auth() error {
+	if _, err := conn.transport.Write([]byte{0}); err != nil {
+		return err
+	}
+	for _, method := range conn.authMethods {
+		ok, err := conn.tryAuth(method)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("dbus: authentication failed")
+}
+
+// tryAuth drives a single mechanism's exchange to completion, reporting
+// whether the server accepted it.
+func (conn *Connection) tryAuth(method Auth) (bool, error) {
+	name, resp, status := method.FirstData()
+	if err := conn.sendAuth(name, resp); err != nil {
+		return false, err
+	}
+	for status == AuthContinue {
+		line, err := readAuthLine(conn.transport)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case bytes.HasPrefix(line, []byte("OK ")):
+			return conn.finishAuth()
+		case bytes.HasPrefix(line, []byte("REJECTED")):
+			return false, nil
+		case bytes.HasPrefix(line, []byte("DATA ")):
+			data, err := hex.DecodeString(string(bytes.TrimSpace(line[len("DATA "):])))
+			if err != nil {
+				return false, err
+			}
+			var nresp []byte
+			nresp, status = method.HandleData(data)
+			if status == AuthError {
+				if _, err := conn.transport.Write([]byte("CANCEL\r\n")); err != nil {
+					return false, err
+				}
+				status = AuthContinue
+				continue
+			}
+			if _, err := conn.transport.Write(append([]byte("DATA "+hex.EncodeToString(nresp)), '\r', '\n')); err != nil {
+				return false, err
+			}
+		default:
+			return false, errors.New("dbus: unexpected response during auth: " + string(line))
+		}
+	}
+	line, err := readAuthLine(conn.transport)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case bytes.HasPrefix(line, []byte("OK ")):
+		return conn.finishAuth()
+	case bytes.HasPrefix(line, []byte("REJECTED")):
+		return false, nil
+	default:
+		return false, errors.New("dbus: unexpected response during auth: " + string(line))
+	}
+}
+
+// sendAuth writes the initial "AUTH <name> [resp]" line for a mechanism.
+func (conn *Connection) sendAuth(name, resp []byte) error {
+	line := append([]byte("AUTH "), name...)
+	if resp != nil {
+		line = append(line, ' ')
+		line = append(line, resp...)
+	}
+	line = append(line, '\r', '\n')
+	_, err := conn.transport.Write(line)
+	return err
+}
+
+// finishAuth negotiates unix file descriptor passing, if the transport
+// supports it, then sends BEGIN to complete a successful handshake.
+func (conn *Connection) finishAuth() (bool, error) {
+	if _, ok := conn.transport.(fdTransport); ok {
+		if err := conn.negotiateUnixFDs(); err != nil {
+			return false, err
+		}
+	}
+	if _, err := conn.transport.Write([]byte("BEGIN\r\n")); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// negotiateUnixFDs asks the server to enable unix file descriptor passing
+// and records whether it agreed in conn.unixFDsEnabled. Only called for
+// transports implementing fdTransport.
+func (conn *Connection) negotiateUnixFDs() error {
+	if _, err := conn.transport.Write([]byte("NEGOTIATE_UNIX_FD\r\n")); err != nil {
+		return err
+	}
+	line, err := readAuthLine(conn.transport)
+	if err != nil {
+		return err
+	}
+	conn.unixFDsEnabled = bytes.HasPrefix(line, []byte("AGREE_UNIX_FD"))
+	return nil
+}
+
+// SupportsUnixFDs reports whether conn's transport and the server both
+// agreed, during the handshake, to pass unix file descriptors alongside
+// messages.
+func (conn *Connection) SupportsUnixFDs() bool {
+	return conn.unixFDsEnabled
+}
+
+// readAuthLine reads a single "\r\n"-terminated SASL line directly off r,
+// one byte at a time. The handshake shares r (conn.transport) with the
+// raw message stream read once BEGIN is sent, so it must never read past
+// the line's trailing '\n': a buffered reader would risk pulling in the
+// first bytes of the post-handshake message stream and losing them.
+func readAuthLine(r io.Reader) ([]byte, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		if _, err := r.Read(b[:]); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return bytes.TrimRight(line, "\r"), nil
+}
@@ -2,15 +2,15 @@ package dbus
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
-	"io"
-	"net"
 	"os"
 	"os/exec"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const defaultSystemBusAddress = "unix:path=/var/run/dbus/system_bus_socket"
@@ -20,7 +20,7 @@ const defaultSystemBusAddress = "unix:path=/var/run/dbus/system_bus_socket"
 //
 // Multiple goroutines may invoke methods on a connection simultaneously.
 type Connection struct {
-	transport       net.Conn
+	transport       transport
 	uuid            string
 	names           []string
 	namesLck        sync.RWMutex
@@ -30,20 +30,63 @@ type Connection struct {
 	repliesLck      sync.RWMutex
 	handlers        map[ObjectPath]map[string]interface{}
 	handlersLck     sync.RWMutex
-	out             chan *Message
+	out             chan *outMessage
 	signals         chan Signal
+	signalSubs      []signalSubscription
 	signalsLck      sync.Mutex
 	eavesdropped    chan *Message
 	eavesdroppedLck sync.Mutex
 	busObj          *Object
+	ctx             context.Context
+	cancel          context.CancelFunc
+	handler         Handler
+	signalHandler   SignalHandler
+	serialGenerator SerialGenerator
+	closed          int32
+	shared          bool
+	refs            int
+
+	inboundInterceptor     Interceptor
+	inboundInterceptorLck  sync.Mutex
+	outboundInterceptor    Interceptor
+	outboundInterceptorLck sync.Mutex
+
+	authMethods    []Auth
+	unixFDsEnabled bool
+
+	receivedFDs    map[uint32][]int
+	receivedFDsLck sync.Mutex
+}
+
+// SetInboundInterceptor installs fn to be called synchronously by inWorker
+// for every message read from the transport, before it is dispatched to a
+// reply channel, the signal handler or the method-call handler. Passing nil
+// removes the current interceptor. fn must not block; it runs on the
+// connection's hot path.
+func (conn *Connection) SetInboundInterceptor(fn Interceptor) {
+	conn.inboundInterceptorLck.Lock()
+	conn.inboundInterceptor = fn
+	conn.inboundInterceptorLck.Unlock()
+}
+
+// SetOutboundInterceptor installs fn to be called synchronously by
+// outWorker for every message before it is encoded and written to the
+// transport. Passing nil removes the current interceptor. fn must not
+// block; it runs on the connection's hot path.
+func (conn *Connection) SetOutboundInterceptor(fn Interceptor) {
+	conn.outboundInterceptorLck.Lock()
+	conn.outboundInterceptor = fn
+	conn.outboundInterceptorLck.Unlock()
 }
 
 // ConnectSessionBus connects to the session message bus and returns the
-// connection or any error that occured.
-func ConnectSessionBus() (*Connection, error) {
+// connection or any error that occured. The passed ConnOptions customize the
+// resulting Connection; see WithHandler, WithSignalHandler and
+// WithSerialGenerator.
+func ConnectSessionBus(opts ...ConnOption) (*Connection, error) {
 	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
 	if address != "" && address != "autolaunch:" {
-		return NewConnection(address)
+		return NewConnection(address, opts...)
 	}
 	cmd := exec.Command("dbus-launch")
 	b, err := cmd.CombinedOutput()
@@ -55,59 +98,53 @@ func ConnectSessionBus() (*Connection, error) {
 	if i == -1 || j == -1 {
 		return nil, errors.New("couldn't determine address of the session bus")
 	}
-	return NewConnection(string(b[i+1 : j]))
+	return NewConnection(string(b[i+1:j]), opts...)
 }
 
 // ConnectSystemBus connects to the system message bus and returns the
-// connection or any error that occured.
-func ConnectSystemBus() (*Connection, error) {
+// connection or any error that occured. The passed ConnOptions customize the
+// resulting Connection; see WithHandler, WithSignalHandler and
+// WithSerialGenerator.
+func ConnectSystemBus(opts ...ConnOption) (*Connection, error) {
 	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
 	if address != "" {
-		return NewConnection(address)
+		return NewConnection(address, opts...)
 	}
-	return NewConnection(defaultSystemBusAddress)
+	return NewConnection(defaultSystemBusAddress, opts...)
 }
 
 // NewConnection establishes a new connection to the message bus specified by
-// address.
-func NewConnection(address string) (*Connection, error) {
+// address. The passed ConnOptions customize the resulting Connection; see
+// WithHandler, WithSignalHandler and WithSerialGenerator.
+func NewConnection(address string, opts ...ConnOption) (*Connection, error) {
 	var err error
 	conn := new(Connection)
-	if strings.HasPrefix(address, "unix") {
-		abstract := getKey(address, "abstract")
-		path := getKey(address, "path")
-		switch {
-		case abstract == "" && path == "":
-			return nil, errors.New("bad address: neither path nor abstract set")
-		case abstract != "" && path == "":
-			conn.transport, err = net.Dial("unix", "@"+abstract)
-			if err != nil {
-				return nil, err
-			}
-		case abstract == "" && path != "":
-			conn.transport, err = net.Dial("unix", path)
-			if err != nil {
-				return nil, err
-			}
-		case abstract != "" && path != "":
-			return nil, errors.New("bad address: both path and abstract set")
-		}
-	} else {
-		return nil, errors.New("bad address: invalid or unsupported transport")
+	conn.transport, err = dialTransport(address)
+	if err != nil {
+		return nil, err
+	}
+	conn.handler = defaultHandler{}
+	conn.signalHandler = defaultSignalHandler{}
+	conn.serialGenerator = defaultSerialGenerator{}
+	conn.authMethods = []Auth{AuthExternal()}
+	for _, opt := range opts {
+		opt(conn)
 	}
 	if err = conn.auth(); err != nil {
 		conn.transport.Close()
 		return nil, err
 	}
 	conn.replies = make(map[uint32]chan *Reply)
-	conn.out = make(chan *Message, 10)
+	conn.out = make(chan *outMessage, 10)
+	conn.receivedFDs = make(map[uint32][]int)
 	conn.handlers = make(map[ObjectPath]map[string]interface{})
 	conn.serial = make(chan uint32)
 	conn.serialUsed = make(chan uint32)
+	conn.ctx, conn.cancel = context.WithCancel(context.Background())
 	conn.busObj = conn.Object("org.freedesktop.DBus", "/org/freedesktop/DBus")
 	go conn.inWorker()
 	go conn.outWorker()
-	go conn.serials()
+	go conn.serialGenerator.Generate(conn.serial, conn.serialUsed)
 	if err = conn.hello(); err != nil {
 		conn.transport.Close()
 		return nil, err
@@ -121,9 +158,59 @@ func (conn *Connection) BusObject() *Object {
 	return conn.busObj
 }
 
+// Context returns a context that is canceled when the connection's
+// underlying transport is closed, either by a call to Close or because the
+// transport itself failed (usually with EOF). Callers can use it to tie
+// waits on external resources to the lifetime of the connection instead of
+// polling Connected or leaking goroutines past Close.
+func (conn *Connection) Context() context.Context {
+	return conn.ctx
+}
+
 // Close closes the underlying transport of the connection and stops all
-// related goroutines.
+// related goroutines. Shared connections obtained from SessionBus or
+// SystemBus reject Close with an error; call Release on those instead so
+// the transport is only torn down once the last reference is gone.
 func (conn *Connection) Close() error {
+	if conn.shared {
+		return errors.New("dbus: cannot Close a shared connection, use Release instead")
+	}
+	return conn.closeTransport()
+}
+
+// Release drops a reference to a shared connection obtained from
+// SessionBus or SystemBus, closing its underlying transport once the last
+// reference is released. It is a no-op on a connection that isn't shared.
+func (conn *Connection) Release() error {
+	if !conn.shared {
+		return nil
+	}
+	sharedBusLck.Lock()
+	conn.refs--
+	last := conn.refs <= 0
+	sharedBusLck.Unlock()
+	if !last {
+		return nil
+	}
+	return conn.closeTransport()
+}
+
+// Connected reports whether the connection's transport is still alive. It
+// becomes false once Close/Release has run to completion, or the transport
+// failed on its own (usually with EOF); SessionBus/SystemBus use it to
+// decide whether to hand out a fresh shared connection.
+func (conn *Connection) Connected() bool {
+	return atomic.LoadInt32(&conn.closed) == 0
+}
+
+// closeTransport tears down the connection's goroutines and transport. It
+// is idempotent: only the first call (whether from Close/Release or from
+// inWorker noticing a dead transport) does any work.
+func (conn *Connection) closeTransport() error {
+	if !atomic.CompareAndSwapInt32(&conn.closed, 0, 1) {
+		return nil
+	}
+	conn.cancel()
 	close(conn.out)
 	conn.signalsLck.Lock()
 	if conn.signals != nil {
@@ -174,6 +261,12 @@ func (conn *Connection) inWorker() {
 	for {
 		msg, err := conn.readMessage()
 		if err == nil {
+			conn.inboundInterceptorLck.Lock()
+			fn := conn.inboundInterceptor
+			conn.inboundInterceptorLck.Unlock()
+			if fn != nil {
+				fn(msg)
+			}
 			dest, _ := msg.Headers[FieldDestination].value.(string)
 			found := false
 			conn.namesLck.RLock()
@@ -217,29 +310,21 @@ func (conn *Connection) inWorker() {
 				}
 				conn.repliesLck.Unlock()
 			case TypeSignal:
-				var signal Signal
-				signal.Name = msg.Headers[FieldMember].value.(string)
-				signal.Body = msg.Body
-				// don't block trying to send a signal
-				conn.signalsLck.Lock()
-				select {
-				case conn.signals <- signal:
-				default:
-				}
-				conn.signalsLck.Unlock()
+				conn.signalHandler.HandleSignal(conn, msg)
 			case TypeMethodCall:
-				go conn.handleCall(msg)
+				go conn.handler.HandleCall(conn, msg)
 			}
 		} else if _, ok := err.(InvalidMessageError); !ok {
 			// Some read error occured (usually EOF); we can't really do
 			// anything but to shut down all stuff and returns errors to all
 			// pending replies.
-			conn.Close()
-			conn.repliesLck.RLock()
-			for _, v := range conn.replies {
+			conn.closeTransport()
+			conn.repliesLck.Lock()
+			for serial, v := range conn.replies {
 				v <- &Reply{nil, err}
+				delete(conn.replies, serial)
 			}
-			conn.repliesLck.RUnlock()
+			conn.repliesLck.Unlock()
 			return
 		}
 		// invalid messages are ignored
@@ -249,8 +334,15 @@ func (conn *Connection) inWorker() {
 // outWorker runs in an own goroutine, encoding and sending messages that are
 // sent to conn.out.
 func (conn *Connection) outWorker() {
-	for msg := range conn.out {
-		err := msg.EncodeTo(conn.transport)
+	for om := range conn.out {
+		msg := om.msg
+		conn.outboundInterceptorLck.Lock()
+		fn := conn.outboundInterceptor
+		conn.outboundInterceptorLck.Unlock()
+		if fn != nil {
+			fn(msg)
+		}
+		err := conn.writeMessage(msg, om.fds)
 		conn.repliesLck.RLock()
 		if err != nil {
 			if conn.replies[msg.Serial] != nil {
@@ -264,12 +356,17 @@ func (conn *Connection) outWorker() {
 	}
 }
 
-// readMessage reads and decodes a single message from the transport.
+// readMessage reads and decodes a single message from the transport. Any
+// unix file descriptors the transport delivers alongside the message bytes
+// are spliced into the decoded body's UnixFD placeholders (see
+// spliceReceivedFDs) and also stashed under the decoded message's serial
+// for callers that need the raw list; retrieve those with ReceivedFDs.
 func (conn *Connection) readMessage() (*Message, error) {
 	// read the first 16 bytes, from which we can figure out the length of the
 	// rest of the message
 	var header [16]byte
-	if _, err := io.ReadFull(conn.transport, header[:]); err != nil {
+	fds, err := conn.readFull(header[:])
+	if err != nil {
 		return nil, err
 	}
 	var order binary.ByteOrder
@@ -290,13 +387,25 @@ func (conn *Connection) readMessage() (*Message, error) {
 		hlen += 8 - (hlen % 8)
 	}
 	rest := make([]byte, int(blen+hlen))
-	if _, err := io.ReadFull(conn.transport, rest); err != nil {
+	restFDs, err := conn.readFull(rest)
+	fds = append(fds, restFDs...)
+	if err != nil {
 		return nil, err
 	}
 	all := make([]byte, 16+len(rest))
 	copy(all, header[:])
 	copy(all[16:], rest)
-	return DecodeMessage(bytes.NewBuffer(all))
+	msg, err := DecodeMessage(bytes.NewBuffer(all))
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) > 0 {
+		spliceReceivedFDs(msg.Body, fds)
+		conn.receivedFDsLck.Lock()
+		conn.receivedFDs[msg.Serial] = fds
+		conn.receivedFDsLck.Unlock()
+	}
+	return msg, nil
 }
 
 // sendError creates an error message corresponding to the parameters and sends
@@ -314,7 +423,7 @@ func (conn *Connection) sendError(e Error, dest string, serial uint32) {
 	if len(e.Body) > 0 {
 		msg.Headers[FieldSignature] = MakeVariant(GetSignature(e.Body...))
 	}
-	conn.out <- msg
+	conn.out <- &outMessage{msg, nil}
 }
 
 // sendReply creates a method reply message corresponding to the parameters and
@@ -331,27 +440,7 @@ func (conn *Connection) sendReply(dest string, serial uint32, values ...interfac
 	if len(values) > 0 {
 		msg.Headers[FieldSignature] = MakeVariant(GetSignature(values...))
 	}
-	conn.out <- msg
-}
-
-// serials runs in an own goroutine, constantly sending serials on conn.serial
-// and reading serials that are ready for "recycling" from conn.serialUsed.
-func (conn *Connection) serials() {
-	s := uint32(1)
-	used := make(map[uint32]bool)
-	used[0] = true // ensure that 0 is never used
-	for {
-		select {
-		case conn.serial <- s:
-			used[s] = true
-			s++
-			for used[s] {
-				s++
-			}
-		case n := <-conn.serialUsed:
-			delete(used, n)
-		}
-	}
+	conn.out <- &outMessage{msg, nil}
 }
 
 // Object returns the object identified by the given destination name and path.
@@ -367,26 +456,83 @@ func (conn *Connection) Object(dest string, path ObjectPath) *Object {
 // The returned cookie is nil if msg isn't a message call or if NoReplyExpected
 // is set.
 //
+// Any UnixFD values in msg.Body are collected automatically and passed to
+// the peer out-of-band alongside the message; see SendWithFDs for the case
+// where the fds aren't already present in the body.
+//
 // The serial member is set to a unique serial before sending.
 func (conn *Connection) Send(msg *Message) Cookie {
+	return conn.SendWithContext(context.Background(), msg)
+}
+
+// SendWithContext acts like Send, but additionally allows the caller to
+// abort waiting for a reply by canceling ctx. If ctx is done before the
+// reply arrives, the cookie yields a Reply whose error is ctx.Err() and the
+// message's serial is dropped from conn.replies so a reply that arrives
+// later is discarded instead of blocking the worker goroutines.
+func (conn *Connection) SendWithContext(ctx context.Context, msg *Message) Cookie {
+	return conn.sendWithContext(ctx, msg, nil)
+}
+
+// SendWithFDs acts like Send, but additionally passes fds to the peer
+// out-of-band alongside msg, on top of any UnixFD values already present
+// in msg.Body (which are collected the same way Send collects them). Use
+// this for fds that aren't represented as body values. It returns an error
+// instead of sending if the handshake didn't negotiate unix file
+// descriptor passing; see SupportsUnixFDs.
+func (conn *Connection) SendWithFDs(msg *Message, fds []int) (Cookie, error) {
+	if len(fds) > 0 && !conn.SupportsUnixFDs() {
+		return nil, errors.New("dbus: transport did not negotiate unix file descriptor passing")
+	}
+	return conn.sendWithContext(context.Background(), msg, fds), nil
+}
+
+func (conn *Connection) sendWithContext(ctx context.Context, msg *Message, fds []int) Cookie {
 	if err := msg.IsValid(); err != nil {
 		c := make(chan *Reply, 1)
 		c <- &Reply{nil, err}
 		return Cookie(c)
 	}
+	fds = append(collectBodyFDs(msg.Body), fds...)
+	if len(fds) > 0 && !conn.SupportsUnixFDs() {
+		c := make(chan *Reply, 1)
+		c <- &Reply{nil, errors.New("dbus: transport did not negotiate unix file descriptor passing")}
+		return Cookie(c)
+	}
 	msg.Serial = <-conn.serial
 	if msg.Type == TypeMethodCall && msg.Flags&FlagNoReplyExpected == 0 {
 		conn.repliesLck.Lock()
 		c := make(chan *Reply, 1)
 		conn.replies[msg.Serial] = c
 		conn.repliesLck.Unlock()
-		conn.out <- msg
+		conn.out <- &outMessage{msg, fds}
+		if done := ctx.Done(); done != nil {
+			go conn.abortOnCancel(done, ctx.Err, msg.Serial, c)
+		}
 		return Cookie(c)
 	}
-	conn.out <- msg
+	conn.out <- &outMessage{msg, fds}
 	return nil
 }
 
+// abortOnCancel waits for ctx to be done and, if serial is still registered
+// in conn.replies, removes it and delivers a context error into c unless c
+// is already full. inWorker unregisters a serial when it delivers its reply;
+// outWorker delivers a write error into c without unregistering it.
+func (conn *Connection) abortOnCancel(done <-chan struct{}, errFunc func() error, serial uint32, c chan *Reply) {
+	<-done
+	conn.repliesLck.Lock()
+	if _, ok := conn.replies[serial]; ok {
+		delete(conn.replies, serial)
+		select {
+		case c <- &Reply{nil, errFunc()}:
+		default:
+		}
+		conn.serialUsed <- serial
+	}
+	conn.repliesLck.Unlock()
+}
+
 // Signal sets the channel to which all received signal messages are forwarded.
 // The caller has to make sure that c is sufficiently buffered; if a message
 // arrives when a write to c is not possible, it is discarded.
@@ -399,6 +545,10 @@ func (conn *Connection) Send(msg *Message) Cookie {
 //
 // If the connection is closed by the server or a call to Close, the channel is
 // also closed.
+//
+// Signal is a degenerate, single-subscriber convenience wrapper with no
+// filtering; callers that need match-rule filtering or more than one
+// subscriber should use AddSignalHandler instead.
 func (conn *Connection) Signal(c chan Signal) {
 	conn.signalsLck.Lock()
 	conn.signals = c
@@ -441,11 +591,12 @@ func getKey(s, key string) string {
 	if i+len(key)+1 >= len(s) || s[i+len(key)] != '=' {
 		return ""
 	}
-	j := strings.Index(s, ",")
+	valueStart := i + len(key) + 1
+	j := strings.Index(s[valueStart:], ",")
 	if j == -1 {
-		j = len(s)
+		j = len(s) - valueStart
 	}
-	return s[i+len(key)+1 : j]
+	return s[valueStart : valueStart+j]
 }
 
 // dereferenceAll returns a slice that, assuming that vs is a slice of pointers
@@ -0,0 +1,87 @@
+package dbus
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestAbortOnCancelNonBlocking reproduces the scenario where a reply is
+// delivered into c (e.g. a write error from outWorker, which leaves the
+// entry registered) right as its context is canceled:
+// abortOnCancel still finds the (now-stale) conn.replies entry, but c is
+// already full. It must drop the context error instead of blocking
+// forever while holding conn.repliesLck.
+func TestAbortOnCancelNonBlocking(t *testing.T) {
+	conn := &Connection{
+		replies:    make(map[uint32]chan *Reply),
+		serialUsed: make(chan uint32, 1),
+	}
+	c := make(chan *Reply, 1)
+	c <- &Reply{nil, nil} // simulate an already-delivered, unread reply
+	conn.replies[1] = c
+
+	done := make(chan struct{})
+	close(done)
+
+	finished := make(chan struct{})
+	go func() {
+		conn.abortOnCancel(done, func() error { return context.Canceled }, 1, c)
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("abortOnCancel blocked sending into a full, unread channel")
+	}
+
+	conn.repliesLck.Lock()
+	_, stale := conn.replies[1]
+	conn.repliesLck.Unlock()
+	if stale {
+		t.Error("abortOnCancel left a stale entry in conn.replies")
+	}
+}
+
+// deadTransport is a transport whose Read immediately fails with EOF, as a
+// real one does once the peer closes the connection.
+type deadTransport struct{ net.Conn }
+
+func (deadTransport) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (deadTransport) Write(p []byte) (int, error) { return len(p), nil }
+func (deadTransport) Close() error                { return nil }
+
+// TestInWorkerClearsRepliesOnClose reproduces a dead transport while a call
+// is in flight: inWorker's close path must delete the pending reply entry
+// it just delivered into, matching what the normal reply-dispatch branch
+// already does, so a later abortOnCancel for the same serial can't find a
+// stale entry and retire its serial a second time.
+func TestInWorkerClearsRepliesOnClose(t *testing.T) {
+	conn := &Connection{
+		transport: deadTransport{},
+		replies:   map[uint32]chan *Reply{1: make(chan *Reply, 1)},
+		out:       make(chan *outMessage, 1),
+	}
+	conn.ctx, conn.cancel = context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		conn.inWorker()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("inWorker did not return after the transport died")
+	}
+
+	conn.repliesLck.Lock()
+	defer conn.repliesLck.Unlock()
+	if len(conn.replies) != 0 {
+		t.Errorf("conn.replies not cleared on transport close: %v", conn.replies)
+	}
+}
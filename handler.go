@@ -0,0 +1,154 @@
+package dbus
+
+import "reflect"
+
+// ConnOption is an option that customizes a Connection created by
+// NewConnection, ConnectSessionBus or ConnectSystemBus.
+type ConnOption func(conn *Connection)
+
+// WithHandler installs h as the connection's method-call dispatcher,
+// replacing the default handler that looks methods up in the map
+// maintained by Export.
+func WithHandler(h Handler) ConnOption {
+	return func(conn *Connection) {
+		conn.handler = h
+	}
+}
+
+// WithSignalHandler installs h as the connection's signal dispatcher,
+// replacing the default handler that forwards every signal to the channel
+// installed via Signal and to the subscribers registered with
+// AddSignalHandler.
+func WithSignalHandler(h SignalHandler) ConnOption {
+	return func(conn *Connection) {
+		conn.signalHandler = h
+	}
+}
+
+// WithSerialGenerator installs g as the connection's serial allocator,
+// replacing the default generator that hands out ascending uint32s.
+func WithSerialGenerator(g SerialGenerator) ConnOption {
+	return func(conn *Connection) {
+		conn.serialGenerator = g
+	}
+}
+
+// Handler dispatches incoming method call messages. Install a custom
+// Handler via WithHandler to route calls through, e.g., a per-object
+// handler tree instead of the built-in handler map.
+type Handler interface {
+	// HandleCall is responsible for producing and sending any reply or
+	// error for msg via conn (e.g. conn.sendReply, conn.sendError).
+	HandleCall(conn *Connection, msg *Message)
+}
+
+// defaultHandler reproduces the connection's built-in behaviour: it looks
+// the destination object and interface up in conn.handlers and invokes the
+// named method on it via reflection.
+type defaultHandler struct{}
+
+func (defaultHandler) HandleCall(conn *Connection, msg *Message) {
+	path, _ := msg.Headers[FieldPath].value.(ObjectPath)
+	iface, _ := msg.Headers[FieldInterface].value.(string)
+	member, _ := msg.Headers[FieldMember].value.(string)
+	sender, _ := msg.Headers[FieldSender].value.(string)
+
+	conn.handlersLck.RLock()
+	target, ok := conn.handlers[path][iface]
+	conn.handlersLck.RUnlock()
+	if !ok {
+		conn.sendError(Error{"org.freedesktop.DBus.Error.UnknownObject", []interface{}{
+			string(path) + " is not exported",
+		}}, sender, msg.Serial)
+		return
+	}
+	method := reflect.ValueOf(target).MethodByName(member)
+	if !method.IsValid() {
+		conn.sendError(Error{"org.freedesktop.DBus.Error.UnknownMethod", []interface{}{
+			"no such method: " + member,
+		}}, sender, msg.Serial)
+		return
+	}
+	args := make([]reflect.Value, len(msg.Body))
+	for i, v := range msg.Body {
+		args[i] = reflect.ValueOf(v)
+	}
+	ret := method.Call(args)
+	if msg.Flags&FlagNoReplyExpected != 0 {
+		return
+	}
+	out := make([]interface{}, len(ret))
+	for i, v := range ret {
+		out[i] = v.Interface()
+	}
+	conn.sendReply(sender, msg.Serial, out...)
+}
+
+// SignalHandler dispatches incoming signal messages. Install a custom
+// SignalHandler via WithSignalHandler to route signals some other way. The
+// default one already fans signals out to every subscriber registered with
+// AddSignalHandler; a custom SignalHandler replaces it, so those
+// subscribers and the Signal channel no longer receive anything.
+type SignalHandler interface {
+	// HandleSignal is responsible for delivering msg to whichever
+	// subscribers are interested in it.
+	HandleSignal(conn *Connection, msg *Message)
+}
+
+// defaultSignalHandler forwards every signal to the channel installed via
+// Signal, and to each channel registered with AddSignalHandler whose match
+// criteria accept it.
+type defaultSignalHandler struct{}
+
+func (defaultSignalHandler) HandleSignal(conn *Connection, msg *Message) {
+	var signal Signal
+	signal.Name, _ = msg.Headers[FieldMember].value.(string)
+	signal.Body = msg.Body
+	// don't block trying to send a signal
+	conn.signalsLck.Lock()
+	select {
+	case conn.signals <- signal:
+	default:
+	}
+	for _, sub := range conn.signalSubs {
+		if !sub.matcher.accepts(msg) {
+			continue
+		}
+		select {
+		case sub.ch <- &signal:
+		default:
+		}
+	}
+	conn.signalsLck.Unlock()
+}
+
+// SerialGenerator allocates the serial numbers used to correlate method
+// calls with their replies.
+type SerialGenerator interface {
+	// Generate runs for the lifetime of the connection: it sends a fresh,
+	// currently-unused serial on serial whenever a receiver is ready, and
+	// retires serials received on used so they can be handed out again.
+	Generate(serial chan<- uint32, used <-chan uint32)
+}
+
+// defaultSerialGenerator reproduces the connection's built-in behaviour of
+// handing out ascending uint32s, skipping any still marked as in use.
+type defaultSerialGenerator struct{}
+
+func (defaultSerialGenerator) Generate(serial chan<- uint32, used <-chan uint32) {
+	s := uint32(1)
+	inUse := make(map[uint32]bool)
+	inUse[0] = true // ensure that 0 is never used
+	for {
+		select {
+		case serial <- s:
+			inUse[s] = true
+			s++
+			for inUse[s] {
+				s++
+			}
+		case n := <-used:
+			delete(inUse, n)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package dbus
+
+import "testing"
+
+// TestConnOptionsSetFields verifies that each ConnOption writes to the
+// Connection field it documents, instead of e.g. silently no-op'ing or
+// targeting the wrong field.
+func TestConnOptionsSetFields(t *testing.T) {
+	conn := &Connection{}
+	h := defaultHandler{}
+	sh := defaultSignalHandler{}
+	sg := defaultSerialGenerator{}
+
+	WithHandler(h)(conn)
+	WithSignalHandler(sh)(conn)
+	WithSerialGenerator(sg)(conn)
+
+	if conn.handler != Handler(h) {
+		t.Fatal("WithHandler did not install the given Handler")
+	}
+	if conn.signalHandler != SignalHandler(sh) {
+		t.Fatal("WithSignalHandler did not install the given SignalHandler")
+	}
+	if conn.serialGenerator != SerialGenerator(sg) {
+		t.Fatal("WithSerialGenerator did not install the given SerialGenerator")
+	}
+}
+
+// TestDefaultSerialGeneratorAscends verifies that defaultSerialGenerator
+// hands out ascending, distinct serials starting at 1 (0 is reserved).
+func TestDefaultSerialGeneratorAscends(t *testing.T) {
+	serial := make(chan uint32)
+	used := make(chan uint32)
+	go defaultSerialGenerator{}.Generate(serial, used)
+
+	for want := uint32(1); want <= 5; want++ {
+		if got := <-serial; got != want {
+			t.Fatalf("got serial %d, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package dbus
+
+import (
+	"fmt"
+	"io"
+)
+
+// Interceptor is invoked synchronously by a Connection for every message
+// passing through it — inbound messages after they are read but before
+// they are dispatched, outbound messages before they are encoded onto the
+// wire. Install one with SetInboundInterceptor/SetOutboundInterceptor to
+// implement tracing, auditing, metrics or wire-format debugging without
+// forking the package.
+//
+// Interceptors run on the connection's hot path (inWorker/outWorker) and
+// must not block.
+type Interceptor func(msg *Message)
+
+// LoggingInterceptor returns an Interceptor that writes one line per
+// message to w, including its serial, type, flags, headers and body. It is
+// a reference implementation suitable for installing via
+// SetInboundInterceptor/SetOutboundInterceptor to dump traffic while
+// debugging.
+func LoggingInterceptor(w io.Writer) Interceptor {
+	return func(msg *Message) {
+		fmt.Fprintf(w, "serial=%d type=%v flags=%v headers=%v body=%v\n",
+			msg.Serial, msg.Type, msg.Flags, msg.Headers, msg.Body)
+	}
+}
@@ -0,0 +1,57 @@
+package dbus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestSetInboundInterceptor verifies that SetInboundInterceptor installs a
+// callback that receives subsequent messages, and that passing nil clears
+// it again.
+func TestSetInboundInterceptor(t *testing.T) {
+	conn := &Connection{}
+	var got *Message
+	conn.SetInboundInterceptor(func(msg *Message) { got = msg })
+
+	msg := &Message{Serial: 42}
+	conn.inboundInterceptor(msg)
+	if got != msg {
+		t.Fatal("installed inbound interceptor was not invoked with the message")
+	}
+
+	conn.SetInboundInterceptor(nil)
+	if conn.inboundInterceptor != nil {
+		t.Fatal("SetInboundInterceptor(nil) did not clear the interceptor")
+	}
+}
+
+// TestSetOutboundInterceptor verifies the same for
+// SetOutboundInterceptor, which guards the outWorker hot path instead.
+func TestSetOutboundInterceptor(t *testing.T) {
+	conn := &Connection{}
+	var got *Message
+	conn.SetOutboundInterceptor(func(msg *Message) { got = msg })
+
+	msg := &Message{Serial: 7}
+	conn.outboundInterceptor(msg)
+	if got != msg {
+		t.Fatal("installed outbound interceptor was not invoked with the message")
+	}
+
+	conn.SetOutboundInterceptor(nil)
+	if conn.outboundInterceptor != nil {
+		t.Fatal("SetOutboundInterceptor(nil) did not clear the interceptor")
+	}
+}
+
+// TestLoggingInterceptor verifies that the reference Interceptor writes one
+// line naming the message's serial.
+func TestLoggingInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	LoggingInterceptor(&buf)(&Message{Serial: 7, Type: TypeMethodCall})
+
+	if !strings.Contains(buf.String(), "serial=7") {
+		t.Fatalf("got %q, want it to mention serial=7", buf.String())
+	}
+}
@@ -0,0 +1,118 @@
+package dbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Flags represents the possible flags of a method call message.
+type Flags byte
+
+const (
+	// FlagNoReplyExpected signals that the message is not expected to
+	// generate a reply. If this flag is set on outgoing messages, any
+	// possible reply will be discarded.
+	FlagNoReplyExpected Flags = 1 << iota
+
+	// FlagNoAutoStart signals that the bus must not launch an owner for
+	// the destination name if one isn't currently running.
+	FlagNoAutoStart
+)
+
+// Object represents a remote object on which methods can be invoked.
+type Object struct {
+	conn *Connection
+	dest string
+	path ObjectPath
+}
+
+// Call calls a method with the given arguments on o and returns without
+// waiting for the reply; use the returned Cookie's Reply or Store to block
+// until the reply is received or an error occurs. The method parameter must
+// be formatted as "interface.method", e.g. "org.freedesktop.DBus.Hello".
+func (o *Object) Call(method string, flags Flags, args ...interface{}) Cookie {
+	return o.CallWithContext(context.Background(), method, flags, args...)
+}
+
+// CallWithContext acts like Call, but additionally allows the caller to
+// abort the call early by canceling ctx. If ctx is done before a reply
+// arrives, the cookie's reply carries ctx.Err() instead.
+func (o *Object) CallWithContext(ctx context.Context, method string, flags Flags, args ...interface{}) Cookie {
+	iface := ""
+	if idx := strings.LastIndex(method, "."); idx != -1 {
+		iface = method[:idx]
+		method = method[idx+1:]
+	}
+	msg := new(Message)
+	msg.Type = TypeMethodCall
+	msg.Flags = flags & (FlagNoAutoStart | FlagNoReplyExpected)
+	msg.Headers = make(map[HeaderField]Variant)
+	msg.Headers[FieldPath] = MakeVariant(o.path)
+	msg.Headers[FieldDestination] = MakeVariant(o.dest)
+	msg.Headers[FieldMember] = MakeVariant(method)
+	if iface != "" {
+		msg.Headers[FieldInterface] = MakeVariant(iface)
+	}
+	msg.Body = args
+	if len(args) > 0 {
+		msg.Headers[FieldSignature] = MakeVariant(GetSignature(args...))
+	}
+	return Cookie(o.conn.SendWithContext(ctx, msg))
+}
+
+// Cookie is returned by a call to Object.Call and can be used to retrieve
+// the eventual reply.
+type Cookie chan *Reply
+
+// Reply blocks until the reply for this call is available.
+func (c Cookie) Reply() *Reply {
+	return <-c
+}
+
+// Store blocks until the reply is available and, if the call didn't return
+// an error, copies its returned values into retvalues, which must be
+// pointers to the expected types.
+func (c Cookie) Store(retvalues ...interface{}) error {
+	reply := <-c
+	if reply.Err != nil {
+		return reply.Err
+	}
+	return Store(reply.Body, retvalues...)
+}
+
+// Reply represents the result of a method call: either the returned values
+// or the error that occured while making the call or waiting for the reply.
+type Reply struct {
+	Body []interface{}
+	Err  error
+}
+
+// Store copies the values in src into the pointers in dest. It is used to
+// convert the body of a method reply into the types requested by the caller.
+func Store(src []interface{}, dest ...interface{}) error {
+	if len(src) != len(dest) {
+		return errors.New("dbus.Store: wrong number of values")
+	}
+	for i := range src {
+		if err := storeInterface(src[i], dest[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storeInterface(src, dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr {
+		return errors.New("dbus.Store: destination is not a pointer")
+	}
+	sv := reflect.ValueOf(src)
+	if !sv.Type().AssignableTo(dv.Elem().Type()) {
+		return errors.New("dbus.Store: value of type " + sv.Type().String() +
+			" is not assignable to type " + dv.Elem().Type().String())
+	}
+	dv.Elem().Set(sv)
+	return nil
+}
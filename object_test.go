@@ -0,0 +1,146 @@
+package dbus
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeSerialConn returns a Connection wired up just enough to drive
+// CallWithContext/SendWithContext without a real transport: a running
+// serial generator and a buffered out channel the test can read the
+// outgoing message from and reply to by hand.
+func fakeSerialConn() *Connection {
+	conn := &Connection{
+		replies:    make(map[uint32]chan *Reply),
+		serial:     make(chan uint32),
+		serialUsed: make(chan uint32, 1),
+		out:        make(chan *outMessage, 1),
+	}
+	go defaultSerialGenerator{}.Generate(conn.serial, conn.serialUsed)
+	return conn
+}
+
+// TestCallWithContextSplitsMethodAndInterface verifies that Call splits a
+// dotted "interface.method" string into the FieldInterface/FieldMember
+// headers, sets the destination and path headers from the Object, and
+// registers a reply channel a caller can Store the eventual result from.
+func TestCallWithContextSplitsMethodAndInterface(t *testing.T) {
+	conn := fakeSerialConn()
+	o := conn.Object("org.example.Dest", "/org/example/Path")
+
+	cookie := o.Call("org.example.Iface.Method", 0, "arg")
+
+	om := <-conn.out
+	if got, _ := om.msg.Headers[FieldInterface].value.(string); got != "org.example.Iface" {
+		t.Fatalf("got interface %q, want %q", got, "org.example.Iface")
+	}
+	if got, _ := om.msg.Headers[FieldMember].value.(string); got != "Method" {
+		t.Fatalf("got member %q, want %q", got, "Method")
+	}
+	if got, _ := om.msg.Headers[FieldDestination].value.(string); got != "org.example.Dest" {
+		t.Fatalf("got destination %q, want %q", got, "org.example.Dest")
+	}
+	if got, _ := om.msg.Headers[FieldPath].value.(ObjectPath); got != ObjectPath("/org/example/Path") {
+		t.Fatalf("got path %q, want %q", got, "/org/example/Path")
+	}
+
+	conn.repliesLck.Lock()
+	c, ok := conn.replies[om.msg.Serial]
+	conn.repliesLck.Unlock()
+	if !ok {
+		t.Fatal("Call did not register a reply channel for its serial")
+	}
+	c <- &Reply{Body: []interface{}{"result"}}
+
+	var got string
+	if err := cookie.Store(&got); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if got != "result" {
+		t.Fatalf("got %q, want %q", got, "result")
+	}
+}
+
+// TestCallWithContextNoInterface verifies that a method name with no dot
+// leaves FieldInterface unset instead of emitting an empty one.
+func TestCallWithContextNoInterface(t *testing.T) {
+	conn := fakeSerialConn()
+	o := conn.Object("org.example.Dest", "/org/example/Path")
+	o.Call("Hello", 0)
+
+	om := <-conn.out
+	if _, ok := om.msg.Headers[FieldInterface]; ok {
+		t.Fatalf("expected no FieldInterface header for a method without a dotted interface, got %v",
+			om.msg.Headers[FieldInterface])
+	}
+	if got, _ := om.msg.Headers[FieldMember].value.(string); got != "Hello" {
+		t.Fatalf("got member %q, want %q", got, "Hello")
+	}
+}
+
+// TestCookieReply verifies that Reply blocks until a value is available
+// and returns it.
+func TestCookieReply(t *testing.T) {
+	c := make(Cookie, 1)
+	want := &Reply{Body: []interface{}{"x"}}
+	c <- want
+	if got := c.Reply(); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestCookieStoreReturnsReplyError verifies that Store surfaces a reply's
+// error instead of attempting to decode its (absent) body.
+func TestCookieStoreReturnsReplyError(t *testing.T) {
+	c := make(Cookie, 1)
+	want := errors.New("boom")
+	c <- &Reply{Err: want}
+	if err := c.Store(); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+}
+
+// TestCookieStoreCopiesBody verifies that Store copies a successful
+// reply's body into the given pointers.
+func TestCookieStoreCopiesBody(t *testing.T) {
+	c := make(Cookie, 1)
+	c <- &Reply{Body: []interface{}{"a", int32(7)}}
+
+	var s string
+	var n int32
+	if err := c.Store(&s, &n); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if s != "a" || n != 7 {
+		t.Fatalf("got (%q, %d), want (%q, %d)", s, n, "a", 7)
+	}
+}
+
+// TestStoreTypeMismatch verifies that Store returns an error instead of
+// panicking when a reply value's type isn't assignable to the caller's
+// destination, as happens whenever a peer returns a different type than
+// expected.
+func TestStoreTypeMismatch(t *testing.T) {
+	var n int32
+	if err := Store([]interface{}{"a string"}, &n); err == nil {
+		t.Fatal("expected an error when the reply type doesn't match the destination")
+	}
+}
+
+// TestStoreNonPointerDestination verifies that Store rejects a
+// non-pointer destination instead of panicking.
+func TestStoreNonPointerDestination(t *testing.T) {
+	var n int32
+	if err := Store([]interface{}{int32(1)}, n); err == nil {
+		t.Fatal("expected an error for a non-pointer destination")
+	}
+}
+
+// TestStoreWrongCount verifies that Store rejects a mismatched number of
+// source values and destinations.
+func TestStoreWrongCount(t *testing.T) {
+	var n int32
+	if err := Store([]interface{}{int32(1), int32(2)}, &n); err == nil {
+		t.Fatal("expected an error for a src/dest count mismatch")
+	}
+}
@@ -0,0 +1,51 @@
+package dbus
+
+import "sync"
+
+// sharedBusLck guards sessionBus, systemBus and the refs field of any
+// Connection handed out by SessionBus or SystemBus.
+var sharedBusLck sync.Mutex
+
+var (
+	sessionBus *Connection
+	systemBus  *Connection
+)
+
+// SessionBus returns a shared connection to the session bus. The first call
+// establishes it; later calls reuse it, transparently reconnecting if the
+// previous shared connection's transport has died. Callers must call
+// Release, not Close, when done with the returned connection, since it may
+// be shared with other callers in the process.
+func SessionBus() (*Connection, error) {
+	return sharedBusConn(&sessionBus, func() (*Connection, error) {
+		return ConnectSessionBus()
+	})
+}
+
+// SystemBus returns a shared connection to the system bus, analogous to
+// SessionBus.
+func SystemBus() (*Connection, error) {
+	return sharedBusConn(&systemBus, func() (*Connection, error) {
+		return ConnectSystemBus()
+	})
+}
+
+// sharedBusConn returns *slot, (re)connecting via connect if *slot is nil or
+// its transport has died, and bumps its reference count either way.
+func sharedBusConn(slot **Connection, connect func() (*Connection, error)) (*Connection, error) {
+	sharedBusLck.Lock()
+	defer sharedBusLck.Unlock()
+
+	if *slot != nil && (*slot).Connected() {
+		(*slot).refs++
+		return *slot, nil
+	}
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	conn.shared = true
+	conn.refs = 1
+	*slot = conn
+	return conn, nil
+}
@@ -0,0 +1,65 @@
+package dbus
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// TestSharedBusConnReuses verifies that sharedBusConn hands back the same
+// connection, with its refcount bumped, as long as it reports Connected.
+func TestSharedBusConnReuses(t *testing.T) {
+	var slot *Connection
+	calls := 0
+	connect := func() (*Connection, error) {
+		calls++
+		return new(Connection), nil
+	}
+
+	c1, err := sharedBusConn(&slot, connect)
+	if err != nil {
+		t.Fatalf("sharedBusConn: %v", err)
+	}
+	if calls != 1 || c1.refs != 1 {
+		t.Fatalf("got calls=%d refs=%d after first call, want 1 and 1", calls, c1.refs)
+	}
+
+	c2, err := sharedBusConn(&slot, connect)
+	if err != nil {
+		t.Fatalf("sharedBusConn: %v", err)
+	}
+	if c2 != c1 {
+		t.Fatal("expected the same connection to be reused")
+	}
+	if calls != 1 || c1.refs != 2 {
+		t.Fatalf("got calls=%d refs=%d after second call, want 1 and 2", calls, c1.refs)
+	}
+}
+
+// TestSharedBusConnReconnectsAfterDeath verifies that sharedBusConn
+// discards a dead connection and reconnects, resetting the refcount,
+// instead of handing out the stale slot.
+func TestSharedBusConnReconnectsAfterDeath(t *testing.T) {
+	var slot *Connection
+	calls := 0
+	connect := func() (*Connection, error) {
+		calls++
+		return new(Connection), nil
+	}
+
+	c1, err := sharedBusConn(&slot, connect)
+	if err != nil {
+		t.Fatalf("sharedBusConn: %v", err)
+	}
+	atomic.StoreInt32(&c1.closed, 1) // simulate the transport dying
+
+	c2, err := sharedBusConn(&slot, connect)
+	if err != nil {
+		t.Fatalf("sharedBusConn: %v", err)
+	}
+	if c2 == c1 {
+		t.Fatal("expected a fresh connection after the previous one died")
+	}
+	if calls != 2 || c2.refs != 1 {
+		t.Fatalf("got calls=%d refs=%d after reconnect, want 2 and 1", calls, c2.refs)
+	}
+}
@@ -0,0 +1,136 @@
+package dbus
+
+// MatchOption specifies one criterion a signal must meet to be delivered to
+// a subscriber installed via AddSignalHandler, or to be selected by a match
+// rule installed via AddMatchSignal. Criteria left unset match anything.
+type MatchOption func(*matcher)
+
+// WithMatchSender restricts matches to signals sent by sender.
+func WithMatchSender(sender string) MatchOption {
+	return func(m *matcher) { m.sender = sender }
+}
+
+// WithMatchInterface restricts matches to signals emitted on iface.
+func WithMatchInterface(iface string) MatchOption {
+	return func(m *matcher) { m.iface = iface }
+}
+
+// WithMatchMember restricts matches to signals named member.
+func WithMatchMember(member string) MatchOption {
+	return func(m *matcher) { m.member = member }
+}
+
+// WithMatchObjectPath restricts matches to signals emitted by the object at
+// path.
+func WithMatchObjectPath(path ObjectPath) MatchOption {
+	return func(m *matcher) { m.path = path }
+}
+
+// matcher holds the criteria built up from a list of MatchOptions.
+type matcher struct {
+	sender string
+	iface  string
+	member string
+	path   ObjectPath
+}
+
+// accepts reports whether msg satisfies every criterion set on m.
+func (m *matcher) accepts(msg *Message) bool {
+	if m.sender != "" {
+		if sender, _ := msg.Headers[FieldSender].value.(string); sender != m.sender {
+			return false
+		}
+	}
+	if m.iface != "" {
+		if iface, _ := msg.Headers[FieldInterface].value.(string); iface != m.iface {
+			return false
+		}
+	}
+	if m.member != "" {
+		if member, _ := msg.Headers[FieldMember].value.(string); member != m.member {
+			return false
+		}
+	}
+	if m.path != "" {
+		if path, _ := msg.Headers[FieldPath].value.(ObjectPath); path != m.path {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleString renders m as a D-Bus match rule, as understood by
+// org.freedesktop.DBus.AddMatch / RemoveMatch.
+func (m *matcher) ruleString() string {
+	rule := "type='signal'"
+	if m.sender != "" {
+		rule += ",sender='" + m.sender + "'"
+	}
+	if m.iface != "" {
+		rule += ",interface='" + m.iface + "'"
+	}
+	if m.member != "" {
+		rule += ",member='" + m.member + "'"
+	}
+	if m.path != "" {
+		rule += ",path='" + string(m.path) + "'"
+	}
+	return rule
+}
+
+// signalSubscription pairs a matcher with the channel that signals accepted
+// by it should be delivered to.
+type signalSubscription struct {
+	matcher *matcher
+	ch      chan<- *Signal
+}
+
+// AddMatchSignal installs a match rule on the message bus so that signals
+// satisfying opts start being delivered to this connection. It is usually
+// paired with AddSignalHandler, which filters the connection's own signal
+// dispatch the same way.
+func (conn *Connection) AddMatchSignal(opts ...MatchOption) error {
+	m := new(matcher)
+	for _, opt := range opts {
+		opt(m)
+	}
+	return conn.busObj.Call("org.freedesktop.DBus.AddMatch", 0, m.ruleString()).Store()
+}
+
+// RemoveMatchSignal removes a match rule previously installed with
+// AddMatchSignal.
+func (conn *Connection) RemoveMatchSignal(opts ...MatchOption) error {
+	m := new(matcher)
+	for _, opt := range opts {
+		opt(m)
+	}
+	return conn.busObj.Call("org.freedesktop.DBus.RemoveMatch", 0, m.ruleString()).Store()
+}
+
+// AddSignalHandler registers ch to receive every signal accepted by
+// matches. Unlike Signal, which allows only one consumer, AddSignalHandler
+// may be called any number of times to let disjoint (or overlapping)
+// goroutines each subscribe to the signals they care about. The caller has
+// to make sure that ch is sufficiently buffered; if a matching signal
+// arrives when a send to ch is not possible, it is discarded.
+func (conn *Connection) AddSignalHandler(ch chan<- *Signal, matches ...MatchOption) {
+	m := new(matcher)
+	for _, opt := range matches {
+		opt(m)
+	}
+	conn.signalsLck.Lock()
+	conn.signalSubs = append(conn.signalSubs, signalSubscription{m, ch})
+	conn.signalsLck.Unlock()
+}
+
+// RemoveSignalHandler undoes a previous AddSignalHandler call for ch.
+func (conn *Connection) RemoveSignalHandler(ch chan<- *Signal) {
+	conn.signalsLck.Lock()
+	for i, sub := range conn.signalSubs {
+		if sub.ch == ch {
+			conn.signalSubs = append(conn.signalSubs[:i], conn.signalSubs[i+1:]...)
+			break
+		}
+	}
+	conn.signalsLck.Unlock()
+}
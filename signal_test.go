@@ -0,0 +1,80 @@
+package dbus
+
+import "testing"
+
+// TestMatcherAccepts verifies that matcher.accepts only rejects a signal
+// for criteria that were actually set, and accepts one that satisfies all
+// of them.
+func TestMatcherAccepts(t *testing.T) {
+	m := &matcher{sender: "org.foo", iface: "org.foo.Iface", member: "Changed", path: "/org/foo"}
+
+	matching := &Message{Headers: map[HeaderField]Variant{
+		FieldSender:    MakeVariant("org.foo"),
+		FieldInterface: MakeVariant("org.foo.Iface"),
+		FieldMember:    MakeVariant("Changed"),
+		FieldPath:      MakeVariant(ObjectPath("/org/foo")),
+	}}
+	if !m.accepts(matching) {
+		t.Fatal("expected matcher to accept a signal satisfying every criterion")
+	}
+
+	for field, value := range map[HeaderField]interface{}{
+		FieldSender:    "org.bar",
+		FieldInterface: "org.bar.Iface",
+		FieldMember:    "Other",
+		FieldPath:      ObjectPath("/org/bar"),
+	} {
+		mismatched := &Message{Headers: map[HeaderField]Variant{
+			FieldSender:    MakeVariant("org.foo"),
+			FieldInterface: MakeVariant("org.foo.Iface"),
+			FieldMember:    MakeVariant("Changed"),
+			FieldPath:      MakeVariant(ObjectPath("/org/foo")),
+		}}
+		mismatched.Headers[field] = MakeVariant(value)
+		if m.accepts(mismatched) {
+			t.Fatalf("expected matcher to reject a signal with mismatched %v", field)
+		}
+	}
+}
+
+// TestMatcherAcceptsUnsetCriteria verifies that criteria left unset on the
+// matcher don't constrain which signals are accepted.
+func TestMatcherAcceptsUnsetCriteria(t *testing.T) {
+	m := &matcher{member: "Changed"}
+	msg := &Message{Headers: map[HeaderField]Variant{
+		FieldSender:    MakeVariant("anyone"),
+		FieldInterface: MakeVariant("anything"),
+		FieldMember:    MakeVariant("Changed"),
+	}}
+	if !m.accepts(msg) {
+		t.Fatal("expected matcher to accept a signal matching its only set criterion")
+	}
+}
+
+// TestAddRemoveSignalHandler verifies that AddSignalHandler appends a
+// subscription and RemoveSignalHandler undoes it by channel identity,
+// leaving other subscriptions untouched.
+func TestAddRemoveSignalHandler(t *testing.T) {
+	conn := &Connection{}
+	chA := make(chan *Signal)
+	chB := make(chan *Signal)
+
+	conn.AddSignalHandler(chA, WithMatchMember("A"))
+	conn.AddSignalHandler(chB, WithMatchMember("B"))
+	if len(conn.signalSubs) != 2 {
+		t.Fatalf("got %d subscriptions, want 2", len(conn.signalSubs))
+	}
+
+	conn.RemoveSignalHandler(chA)
+	if len(conn.signalSubs) != 1 {
+		t.Fatalf("got %d subscriptions after removal, want 1", len(conn.signalSubs))
+	}
+	if conn.signalSubs[0].ch != chB {
+		t.Fatal("RemoveSignalHandler removed the wrong subscription")
+	}
+
+	conn.RemoveSignalHandler(chB)
+	if len(conn.signalSubs) != 0 {
+		t.Fatalf("got %d subscriptions after removing the last one, want 0", len(conn.signalSubs))
+	}
+}
@@ -0,0 +1,55 @@
+package dbus
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// transport is the low-level, message-agnostic connection to a message bus,
+// as established by dialing the address scheme registered for it with
+// registerTransport.
+type transport interface {
+	net.Conn
+}
+
+// fdTransport is implemented by transports that can pass file descriptors
+// alongside message bytes, such as unix domain sockets via SCM_RIGHTS.
+// Transports that can't (e.g. tcp) simply don't implement it; callers
+// type-assert to find out.
+type fdTransport interface {
+	transport
+
+	// ReadMessageFDs behaves like Read, additionally returning any file
+	// descriptors received out-of-band with the data.
+	ReadMessageFDs(buf []byte) (n int, fds []int, err error)
+
+	// WriteMessageFDs behaves like Write, additionally passing fds
+	// out-of-band alongside the data.
+	WriteMessageFDs(buf []byte, fds []int) (n int, err error)
+}
+
+// transports maps an address scheme (the part of a D-Bus address before the
+// first colon, e.g. "unix" or "tcp") to the dialer that establishes it.
+// Transports register themselves from an init function in their own file.
+var transports = make(map[string]func(address string) (transport, error))
+
+// registerTransport makes dial available under address strings beginning
+// with "scheme:".
+func registerTransport(scheme string, dial func(address string) (transport, error)) {
+	transports[scheme] = dial
+}
+
+// dialTransport establishes a transport for address by looking up the
+// dialer registered for its scheme.
+func dialTransport(address string) (transport, error) {
+	scheme := address
+	if i := strings.IndexRune(address, ':'); i != -1 {
+		scheme = address[:i]
+	}
+	dial, ok := transports[scheme]
+	if !ok {
+		return nil, errors.New("bad address: invalid or unsupported transport")
+	}
+	return dial(address)
+}
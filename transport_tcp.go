@@ -0,0 +1,69 @@
+package dbus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+func init() {
+	registerTransport("tcp", dialTCP)
+	registerTransport("nonce-tcp", dialNonceTCP)
+}
+
+// dialTCPAddress dials the host= and port= keys of address, honoring an
+// optional family= key ("ipv4" or "ipv6").
+func dialTCPAddress(address string) (net.Conn, error) {
+	host := getKey(address, "host")
+	port := getKey(address, "port")
+	if host == "" || port == "" {
+		return nil, errors.New("bad address: tcp transport requires host and port")
+	}
+	network := "tcp"
+	switch getKey(address, "family") {
+	case "ipv4":
+		network = "tcp4"
+	case "ipv6":
+		network = "tcp6"
+	}
+	return net.Dial(network, net.JoinHostPort(host, port))
+}
+
+// dialTCP dials the "tcp:" address scheme, as used by buses configured with
+// the common tcp transport (e.g. on Windows, or in test rigs).
+func dialTCP(address string) (transport, error) {
+	return dialTCPAddress(address)
+}
+
+// dialNonceTCP dials the "nonce-tcp:" address scheme: like "tcp:", but after
+// connecting it reads the 16-byte nonce from the file named by the
+// noncefile= key and writes it to the socket before the SASL exchange
+// begins, as required by the server.
+func dialNonceTCP(address string) (transport, error) {
+	conn, err := dialTCPAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	noncefile := getKey(address, "noncefile")
+	if noncefile == "" {
+		conn.Close()
+		return nil, errors.New("bad address: nonce-tcp transport requires noncefile")
+	}
+	f, err := os.Open(noncefile)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer f.Close()
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(f, nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,77 @@
+package dbus
+
+import (
+	"net"
+	"testing"
+)
+
+// TestDialTransportUnknownScheme verifies that dialTransport rejects an
+// address whose scheme has no registered dialer.
+func TestDialTransportUnknownScheme(t *testing.T) {
+	if _, err := dialTransport("bogus:foo=bar"); err == nil {
+		t.Fatal("expected an error for an unregistered transport scheme")
+	}
+}
+
+// TestGetKey verifies key extraction from a D-Bus server address, covering
+// the host=/port=/family=/noncefile= keys used by the tcp transports.
+func TestGetKey(t *testing.T) {
+	cases := []struct {
+		address, key, want string
+	}{
+		{"tcp:host=localhost,port=1234", "host", "localhost"},
+		{"tcp:host=localhost,port=1234", "port", "1234"},
+		{"tcp:host=localhost,port=1234,family=ipv6", "family", "ipv6"},
+		{"tcp:host=localhost,port=1234", "family", ""},
+		{"nonce-tcp:host=localhost,port=1234,noncefile=/tmp/n", "noncefile", "/tmp/n"},
+		{"unix:path=/var/run/dbus/system_bus_socket", "path", "/var/run/dbus/system_bus_socket"},
+		{"unix:abstract=foo", "abstract", "foo"},
+		{"unix:path=/var/run/dbus/system_bus_socket", "abstract", ""},
+	}
+	for _, c := range cases {
+		if got := getKey(c.address, c.key); got != c.want {
+			t.Errorf("getKey(%q, %q) = %q, want %q", c.address, c.key, got, c.want)
+		}
+	}
+}
+
+// TestDialTCPAddressRequiresHostAndPort verifies that dialTCPAddress
+// refuses to dial without both host= and port=, without touching the
+// network.
+func TestDialTCPAddressRequiresHostAndPort(t *testing.T) {
+	if _, err := dialTCPAddress("tcp:port=1234"); err == nil {
+		t.Fatal("expected an error when host= is missing")
+	}
+	if _, err := dialTCPAddress("tcp:host=localhost"); err == nil {
+		t.Fatal("expected an error when port= is missing")
+	}
+}
+
+// TestDialNonceTCPRequiresNoncefile verifies that dialNonceTCP connects,
+// then rejects the address and closes the connection if noncefile= is
+// missing, against a real loopback listener.
+func TestDialNonceTCPRequiresNoncefile(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("SplitHostPort: %v", err)
+	}
+	addr := "nonce-tcp:host=" + host + ",port=" + port
+	if _, err := dialNonceTCP(addr); err == nil {
+		t.Fatal("expected an error when noncefile= is missing")
+	}
+}
@@ -0,0 +1,83 @@
+package dbus
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func init() {
+	registerTransport("unix", dialUnix)
+}
+
+// dialUnix dials the "unix:" address scheme, connecting to either an
+// abstract socket (abstract=) or a filesystem path (path=), but not both.
+func dialUnix(address string) (transport, error) {
+	abstract := getKey(address, "abstract")
+	path := getKey(address, "path")
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch {
+	case abstract == "" && path == "":
+		return nil, errors.New("bad address: neither path nor abstract set")
+	case abstract != "" && path == "":
+		conn, err = net.Dial("unix", "@"+abstract)
+	case abstract == "" && path != "":
+		conn, err = net.Dial("unix", path)
+	default:
+		return nil, errors.New("bad address: both path and abstract set")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uc, ok := conn.(*net.UnixConn); ok {
+		return unixTransport{uc}, nil
+	}
+	return conn, nil
+}
+
+// maxUnixFDs bounds the number of file descriptors accepted in a single
+// SCM_RIGHTS control message, matching libdbus' default limit.
+const maxUnixFDs = 16
+
+// unixTransport wraps a connected unix domain socket, adding the ability to
+// send and receive file descriptors alongside message bytes as SCM_RIGHTS
+// ancillary data. It implements fdTransport.
+type unixTransport struct {
+	*net.UnixConn
+}
+
+// ReadMessageFDs implements fdTransport.
+func (t unixTransport) ReadMessageFDs(buf []byte) (n int, fds []int, err error) {
+	oob := make([]byte, syscall.CmsgSpace(maxUnixFDs*4))
+	n, oobn, _, _, err := t.UnixConn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return n, nil, err
+	}
+	if oobn == 0 {
+		return n, nil, nil
+	}
+	scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, nil, err
+	}
+	for _, scm := range scms {
+		rights, err := syscall.ParseUnixRights(&scm)
+		if err != nil {
+			return n, nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return n, fds, nil
+}
+
+// WriteMessageFDs implements fdTransport.
+func (t unixTransport) WriteMessageFDs(buf []byte, fds []int) (n int, err error) {
+	if len(fds) == 0 {
+		return t.UnixConn.Write(buf)
+	}
+	n, _, err = t.UnixConn.WriteMsgUnix(buf, syscall.UnixRights(fds...), nil)
+	return n, err
+}
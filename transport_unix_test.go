@@ -0,0 +1,100 @@
+package dbus
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// unixSocketpair returns a connected pair of unixTransports backed by a
+// real AF_UNIX socketpair, for exercising SCM_RIGHTS without touching the
+// filesystem.
+func unixSocketpair(t *testing.T) (unixTransport, unixTransport) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("socketpair: %v", err)
+	}
+	left, err := net.FileConn(os.NewFile(uintptr(fds[0]), "left"))
+	if err != nil {
+		t.Fatalf("FileConn(left): %v", err)
+	}
+	right, err := net.FileConn(os.NewFile(uintptr(fds[1]), "right"))
+	if err != nil {
+		t.Fatalf("FileConn(right): %v", err)
+	}
+	t.Cleanup(func() {
+		left.Close()
+		right.Close()
+	})
+	return unixTransport{left.(*net.UnixConn)}, unixTransport{right.(*net.UnixConn)}
+}
+
+// TestUnixTransportPassesFDs verifies that a file descriptor written with
+// WriteMessageFDs is usable on the receiving end after ReadMessageFDs, and
+// that the accompanying message bytes arrive unchanged.
+func TestUnixTransportPassesFDs(t *testing.T) {
+	left, right := unixSocketpair(t)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer r.Close()
+
+	payload := []byte("hello")
+	if _, err := left.WriteMessageFDs(payload, []int{int(w.Fd())}); err != nil {
+		t.Fatalf("WriteMessageFDs: %v", err)
+	}
+	w.Close()
+
+	buf := make([]byte, len(payload))
+	n, fds, err := right.ReadMessageFDs(buf)
+	if err != nil {
+		t.Fatalf("ReadMessageFDs: %v", err)
+	}
+	if string(buf[:n]) != string(payload) {
+		t.Fatalf("got body %q, want %q", buf[:n], payload)
+	}
+	if len(fds) != 1 {
+		t.Fatalf("got %d fds, want 1", len(fds))
+	}
+
+	received := os.NewFile(uintptr(fds[0]), "received")
+	defer received.Close()
+	if _, err := received.WriteString("ping"); err != nil {
+		t.Fatalf("received fd is not writable: %v", err)
+	}
+
+	got := make([]byte, 4)
+	if _, err := r.Read(got); err != nil {
+		t.Fatalf("reading back through the original pipe end: %v", err)
+	}
+	if string(got) != "ping" {
+		t.Fatalf("got %q through the passed fd, want %q", got, "ping")
+	}
+}
+
+// TestUnixTransportWithoutFDs verifies WriteMessageFDs/ReadMessageFDs
+// behave like plain Write/Read when there are no fds to pass.
+func TestUnixTransportWithoutFDs(t *testing.T) {
+	left, right := unixSocketpair(t)
+
+	payload := []byte("no fds here")
+	if _, err := left.WriteMessageFDs(payload, nil); err != nil {
+		t.Fatalf("WriteMessageFDs: %v", err)
+	}
+
+	buf := make([]byte, len(payload))
+	n, fds, err := right.ReadMessageFDs(buf)
+	if err != nil {
+		t.Fatalf("ReadMessageFDs: %v", err)
+	}
+	if string(buf[:n]) != string(payload) {
+		t.Fatalf("got body %q, want %q", buf[:n], payload)
+	}
+	if len(fds) != 0 {
+		t.Fatalf("got %d fds, want 0", len(fds))
+	}
+}
@@ -0,0 +1,115 @@
+package dbus
+
+import (
+	"bytes"
+	"io"
+)
+
+// UnixFD is a unix file descriptor carried in a message body as a type 'h'
+// value, passed alongside the message's bytes, out of band, as SCM_RIGHTS
+// ancillary data. To send fds, set them directly as UnixFD elements of a
+// Message's Body; Send and SendWithFDs collect them automatically and pass
+// them over the transport alongside the encoded message. On receipt,
+// readMessage splices the fds delivered with a message back into the
+// decoded body in the same way, replacing each UnixFD placeholder with the
+// handle it stands for, in encounter order.
+//
+// Encoding the numeric index a UnixFD's position corresponds to on the
+// wire, and decoding one out of a message body, is the signature
+// marshaler's responsibility and isn't implemented by this package;
+// collectBodyFDs and spliceReceivedFDs only do the body-level
+// correlation, not the wire-level one.
+type UnixFD uintptr
+
+// collectBodyFDs returns the fds held by body's UnixFD elements, in
+// encounter order, for passing out-of-band alongside the message they
+// belong to.
+func collectBodyFDs(body []interface{}) []int {
+	var fds []int
+	for _, v := range body {
+		if fd, ok := v.(UnixFD); ok {
+			fds = append(fds, int(fd))
+		}
+	}
+	return fds
+}
+
+// spliceReceivedFDs replaces each UnixFD placeholder in a decoded body, in
+// encounter order, with the real file descriptor delivered alongside the
+// message at the same position. Extra received fds beyond the number of
+// placeholders, or placeholders beyond the number of received fds, are
+// left alone.
+func spliceReceivedFDs(body []interface{}, fds []int) {
+	i := 0
+	for idx, v := range body {
+		if _, ok := v.(UnixFD); !ok {
+			continue
+		}
+		if i >= len(fds) {
+			return
+		}
+		body[idx] = UnixFD(fds[i])
+		i++
+	}
+}
+
+// outMessage pairs a message with the unix file descriptors, if any, that
+// should travel with it out-of-band. conn.out carries these instead of
+// bare *Message so outWorker can hand fds to a fdTransport without needing
+// a field on Message itself.
+type outMessage struct {
+	msg *Message
+	fds []int
+}
+
+// ReceivedFDs returns, and forgets, the unix file descriptors delivered
+// alongside the message with the given serial, if the transport and the
+// peer negotiated unix file descriptor passing during the handshake (see
+// SupportsUnixFDs). It returns nil if none were delivered or it has
+// already been called for that serial.
+func (conn *Connection) ReceivedFDs(serial uint32) []int {
+	conn.receivedFDsLck.Lock()
+	fds := conn.receivedFDs[serial]
+	delete(conn.receivedFDs, serial)
+	conn.receivedFDsLck.Unlock()
+	return fds
+}
+
+// readFull reads len(buf) bytes from conn.transport into buf, like
+// io.ReadFull, additionally collecting any unix file descriptors an
+// fdTransport delivers alongside the data.
+func (conn *Connection) readFull(buf []byte) ([]int, error) {
+	ft, ok := conn.transport.(fdTransport)
+	if !ok {
+		_, err := io.ReadFull(conn.transport, buf)
+		return nil, err
+	}
+	var fds []int
+	for len(buf) > 0 {
+		n, nfds, err := ft.ReadMessageFDs(buf)
+		fds = append(fds, nfds...)
+		buf = buf[n:]
+		if err != nil {
+			return fds, err
+		}
+		if n == 0 {
+			return fds, io.ErrNoProgress
+		}
+	}
+	return fds, nil
+}
+
+// writeMessage encodes msg onto conn.transport, passing fds out-of-band
+// alongside it if conn.transport is a fdTransport and fds is non-empty.
+func (conn *Connection) writeMessage(msg *Message, fds []int) error {
+	ft, ok := conn.transport.(fdTransport)
+	if !ok || len(fds) == 0 {
+		return msg.EncodeTo(conn.transport)
+	}
+	var buf bytes.Buffer
+	if err := msg.EncodeTo(&buf); err != nil {
+		return err
+	}
+	_, err := ft.WriteMessageFDs(buf.Bytes(), fds)
+	return err
+}
@@ -0,0 +1,51 @@
+package dbus
+
+import "testing"
+
+// TestCollectBodyFDs verifies that collectBodyFDs finds UnixFD body
+// elements in order and leaves other values alone.
+func TestCollectBodyFDs(t *testing.T) {
+	body := []interface{}{"hello", UnixFD(7), int32(42), UnixFD(9)}
+	fds := collectBodyFDs(body)
+	if len(fds) != 2 || fds[0] != 7 || fds[1] != 9 {
+		t.Fatalf("got %v, want [7 9]", fds)
+	}
+}
+
+// TestCollectBodyFDsNone verifies collectBodyFDs returns nil for a body
+// with no UnixFD values.
+func TestCollectBodyFDsNone(t *testing.T) {
+	if fds := collectBodyFDs([]interface{}{"hello", int32(42)}); fds != nil {
+		t.Fatalf("got %v, want nil", fds)
+	}
+}
+
+// TestSpliceReceivedFDs verifies that spliceReceivedFDs replaces each
+// UnixFD placeholder, in order, with the real fd delivered at the same
+// position, leaving non-UnixFD elements untouched.
+func TestSpliceReceivedFDs(t *testing.T) {
+	body := []interface{}{"hello", UnixFD(0), int32(42), UnixFD(1)}
+	spliceReceivedFDs(body, []int{11, 22})
+
+	if body[0] != "hello" || body[2] != int32(42) {
+		t.Fatalf("non-UnixFD elements were modified: %v", body)
+	}
+	if body[1] != UnixFD(11) || body[3] != UnixFD(22) {
+		t.Fatalf("got %v, want placeholders replaced with [11 22]", body)
+	}
+}
+
+// TestSpliceReceivedFDsShortage verifies that placeholders beyond the
+// number of delivered fds are left alone instead of panicking or wrapping
+// around.
+func TestSpliceReceivedFDsShortage(t *testing.T) {
+	body := []interface{}{UnixFD(0), UnixFD(1)}
+	spliceReceivedFDs(body, []int{11})
+
+	if body[0] != UnixFD(11) {
+		t.Fatalf("got %v, want first placeholder replaced with 11", body[0])
+	}
+	if body[1] != UnixFD(1) {
+		t.Fatalf("got %v, want second placeholder left untouched", body[1])
+	}
+}